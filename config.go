@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Config is the schema for --config.file. It maps logical probe module
+// names to fixed Lambda invocation parameters, mirroring the blackbox
+// exporter's module-based configuration.
+type Config struct {
+	Modules map[string]Module `yaml:"modules"`
+}
+
+// Module describes how to invoke a Lambda function for a single probe
+// module: which alias/version to call, which IAM role to assume, how long
+// to wait, and what fixed payload fields to send.
+type Module struct {
+	Qualifier string                 `yaml:"qualifier,omitempty"`
+	RoleARN   string                 `yaml:"role_arn,omitempty"`
+	Timeout   time.Duration          `yaml:"timeout,omitempty"`
+	Payload   map[string]interface{} `yaml:"payload,omitempty"`
+}
+
+// LoadConfig reads and parses a --config.file. A missing path is not an
+// error; it yields an empty Config so modules are simply unavailable.
+func LoadConfig(path string) (*Config, error) {
+	if path == "" {
+		return &Config{}, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read config file: %s", err)
+	}
+
+	cfg := &Config{}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("could not parse config file: %s", err)
+	}
+
+	return cfg, nil
+}
@@ -1,15 +1,25 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
+	"runtime"
 	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/ec2metadata"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/lambda"
@@ -18,7 +28,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	dto "github.com/prometheus/client_model/go"
 	"github.com/prometheus/common/expfmt"
-	"github.com/prometheus/common/log"
+	"github.com/sirupsen/logrus"
 )
 
 const (
@@ -32,140 +42,684 @@ var (
 		[]string{"region"},
 		nil,
 	)
+
+	// lastSuccessDesc and scrapeErrorDesc follow the node_exporter textfile
+	// collector convention so staleness is visible even when cached data is
+	// still being served.
+	lastSuccessDesc = prometheus.NewDesc(
+		namespace+"_last_success_timestamp_seconds",
+		"Unix timestamp of the last successful invocation of this target's Lambda function.",
+		[]string{"function_name", "qualifier"},
+		nil,
+	)
+	scrapeErrorDesc = prometheus.NewDesc(
+		namespace+"_scrape_error",
+		"1 if the last invocation of this target's Lambda function failed, 0 otherwise.",
+		[]string{"function_name", "qualifier"},
+		nil,
+	)
+
+	lambdaTimeout            = 10 * time.Second
+	lambdaConcurrency        = 10
+	lambdaRefreshInterval    = 1 * time.Minute
+	lambdaRefreshIdleTimeout = 10 * time.Minute
+	lambdaInvocationType     = lambda.InvocationTypeRequestResponse
 )
 
+// lambdaInvocationTypeWarm is not a real Lambda InvocationType; it tells
+// this exporter to invoke RequestResponse as usual but also keep the
+// function's container warm with periodic throwaway Event invocations.
+const lambdaInvocationTypeWarm = "Warm"
+
+var (
+	invokeErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "aws_lambda_exporter_invoke_errors_total",
+		Help: "Total number of errors invoking a target Lambda function, by function_name.",
+	}, []string{"function_name"})
+
+	lambdaInvokeErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "aws_lambda_exporter_lambda_invoke_errors_total",
+		Help: "Total number of errors invoking the target Lambda function, by error_type.",
+	}, []string{"function_name", "region", "error_type"})
+
+	lambdaInvokeDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "aws_lambda_exporter_lambda_invoke_duration_seconds",
+		Help:    "Duration of a single Lambda Invoke call.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"function_name"})
+
+	buildInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "aws_lambda_exporter_build_info",
+		Help: "A metric with a constant '1' value labeled by version, revision, and the Go version the exporter was built with.",
+	}, []string{"version", "revision", "go_version"})
+
+	// version and revision are set at build time via -ldflags
+	// "-X main.version=... -X main.revision=...".
+	version  = "unknown"
+	revision = "unknown"
+)
+
+func init() {
+	prometheus.MustRegister(invokeErrorsTotal)
+	prometheus.MustRegister(lambdaInvokeErrorsTotal)
+	prometheus.MustRegister(lambdaInvokeDuration)
+	prometheus.MustRegister(buildInfo)
+	buildInfo.WithLabelValues(version, revision, runtime.Version()).Set(1)
+}
+
+// lambdaTarget is one Lambda function (optionally pinned to an alias or
+// version, assumed into a role, and sent a fixed or scrape-supplied
+// payload) that a single scrape fans out to.
+type lambdaTarget struct {
+	FunctionName string
+	Qualifier    string
+	RoleARN      string
+	Timeout      time.Duration
+	Payload      map[string]interface{}
+}
+
 type Exporter struct {
-	lambdaClient lambdaiface.LambdaAPI
-	functionName string
+	session     *session.Session
+	region      string
+	targets     []lambdaTarget
+	timeout     time.Duration
+	concurrency int
+
+	clientMu sync.Mutex
+	clients  map[string]lambdaiface.LambdaAPI
 }
 
-func NewExporter(region string, functionName string) (*Exporter, error) {
+func NewExporter(region string, targets []lambdaTarget) (*Exporter, error) {
 	cfg := &aws.Config{Region: aws.String(region)}
 	sess := session.Must(session.NewSession(cfg))
 	return &Exporter{
-		lambdaClient: lambda.New(sess),
-		functionName: functionName,
+		session:     sess,
+		region:      region,
+		targets:     targets,
+		timeout:     lambdaTimeout,
+		concurrency: lambdaConcurrency,
+		clients:     make(map[string]lambdaiface.LambdaAPI),
 	}, nil
 }
 
-var descMap = make(map[string]*prometheus.Desc)
+// lambdaClientFor returns a Lambda client for the target's own account,
+// assuming RoleARN via STS when one is configured. Clients (and, for an
+// assumed role, the underlying stscreds.Credentials) are cached per RoleARN
+// and reused, since refreshLoop and warmInvoke call this on every tick for
+// the lifetime of the Exporter and recreating stscreds.Credentials each time
+// would force a fresh AssumeRole call instead of letting it cache the
+// assumed token until near expiry.
+func (e *Exporter) lambdaClientFor(t lambdaTarget) lambdaiface.LambdaAPI {
+	e.clientMu.Lock()
+	defer e.clientMu.Unlock()
 
-func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
-	parsed, err := e.invokeLambda()
-	if err != nil {
-		log.Error(err)
-		return
+	if client, ok := e.clients[t.RoleARN]; ok {
+		return client
+	}
+
+	var client lambdaiface.LambdaAPI
+	if t.RoleARN == "" {
+		client = lambda.New(e.session)
+	} else {
+		creds := stscreds.NewCredentials(e.session, t.RoleARN)
+		client = lambda.New(e.session, &aws.Config{Credentials: creds})
 	}
+	e.clients[t.RoleARN] = client
+	return client
+}
+
+// descKey scopes a cached Desc by the target that defined it, not just the
+// metric name: different targets can legitimately report a metric with the
+// same name but a different set of custom labels (e.g. two probe modules
+// both emitting "up").
+type descKey struct {
+	functionName string
+	qualifier    string
+	metricName   string
+}
+
+// descEntry pairs a Desc with the sorted dynamic label names it was built
+// from, so Collect can detect a target reporting a label set that doesn't
+// match what Describe already registered for it.
+type descEntry struct {
+	desc   *prometheus.Desc
+	labels []string
+}
 
-	for _, mf := range parsed {
-		lm := make(map[string]bool)
-		for _, m := range mf.GetMetric() {
-			for _, l := range m.GetLabel() {
-				lm[l.GetName()] = true
+// descMap and descMapMu guard it the same way cacheMu guards cache: a
+// new Exporter is built per scrape, so descMap is shared across
+// concurrently-served HTTP requests and must not be read or written
+// without holding descMapMu.
+var (
+	descMapMu sync.Mutex
+	descMap   = make(map[descKey]*descEntry)
+)
+
+// dynamicLabelNames returns the sorted, non-function_name/qualifier label
+// names present on a metric, for building or validating a descEntry.
+func dynamicLabelNames(m *dto.Metric) []string {
+	names := []string{}
+	for _, l := range m.GetLabel() {
+		names = append(names, l.GetName())
+	}
+	sort.Strings(names)
+	return names
+}
+
+// labelNamesMatch reports whether a metric's sorted label names are exactly
+// the ones a descEntry was built from.
+func labelNamesMatch(entry []string, m *dto.Metric) bool {
+	got := dynamicLabelNames(m)
+	if len(got) != len(entry) {
+		return false
+	}
+	for i, name := range got {
+		if name != entry[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// invocation holds the outcome of invoking a single target Lambda,
+// including when that result was last refreshed (see invokeCached).
+type invocation struct {
+	target      lambdaTarget
+	parsed      map[string]*dto.MetricFamily
+	err         error
+	lastSuccess time.Time
+}
+
+// invokeSem bounds how many Lambda invocations run concurrently across all
+// scrapes; sized from lambdaConcurrency once flags are parsed.
+var invokeSem = make(chan struct{}, lambdaConcurrency)
+
+// cacheKey identifies one distinct Lambda invocation shape: a function,
+// qualifier, the role it's invoked under, and the payload sent to it.
+// RoleARN must be part of the key: two targets that otherwise look
+// identical but assume different roles are not interchangeable.
+type cacheKey struct {
+	functionName string
+	qualifier    string
+	roleARN      string
+	payloadHash  string
+}
+
+func (t lambdaTarget) cacheKey() cacheKey {
+	key := cacheKey{functionName: t.FunctionName, qualifier: t.Qualifier, roleARN: t.RoleARN}
+	if len(t.Payload) > 0 {
+		b, _ := json.Marshal(t.Payload)
+		sum := sha1.Sum(b)
+		key.payloadHash = fmt.Sprintf("%x", sum)
+	}
+	return key
+}
+
+type cacheEntry struct {
+	parsed    map[string]*dto.MetricFamily
+	err       error
+	timestamp time.Time
+}
+
+var (
+	cacheMu       sync.Mutex
+	cache         = make(map[cacheKey]*cacheEntry)
+	refreshing    = make(map[cacheKey]bool)
+	lastRequested = make(map[cacheKey]time.Time)
+	lastTarget    = make(map[cacheKey]lambdaTarget)
+)
+
+// invokeCached serves a target's metrics from the TTL cache, populating it
+// synchronously on first use and starting a background refresher so later
+// scrapes never block on a cold Lambda container. It also records that the
+// target was scraped just now (so refreshLoop knows when to stop) and the
+// target itself (so refreshLoop keeps using the most recently seen Timeout
+// rather than whichever target happened to start the refresher).
+func (e *Exporter) invokeCached(t lambdaTarget) (map[string]*dto.MetricFamily, error, time.Time) {
+	key := t.cacheKey()
+
+	cacheMu.Lock()
+	entry, ok := cache[key]
+	lastRequested[key] = time.Now()
+	lastTarget[key] = t
+	startRefresher := !refreshing[key]
+	if startRefresher {
+		refreshing[key] = true
+	}
+	cacheMu.Unlock()
+
+	if startRefresher {
+		go e.refreshLoop(key, t)
+	}
+
+	if ok {
+		return entry.parsed, entry.err, entry.timestamp
+	}
+
+	parsed, err := e.invokeLambdaBounded(t)
+	entry = &cacheEntry{parsed: parsed, err: err}
+	if err == nil {
+		entry.timestamp = time.Now()
+	}
+	cacheMu.Lock()
+	cache[key] = entry
+	cacheMu.Unlock()
+	return entry.parsed, entry.err, entry.timestamp
+}
+
+// refreshLoop keeps one cache entry warm at lambdaRefreshInterval, so a
+// Lambda's cold-start latency is only ever paid in the background. It exits
+// and evicts its cache entry once the target has gone lambdaRefreshIdleTimeout
+// without a scrape, so an ad hoc or one-off scrape doesn't invoke the Lambda
+// forever.
+func (e *Exporter) refreshLoop(key cacheKey, t lambdaTarget) {
+	ticker := time.NewTicker(lambdaRefreshInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		cacheMu.Lock()
+		idle := time.Since(lastRequested[key]) > lambdaRefreshIdleTimeout
+		if idle {
+			delete(cache, key)
+			delete(refreshing, key)
+			delete(lastRequested, key)
+			delete(lastTarget, key)
+		} else {
+			t = lastTarget[key]
+		}
+		cacheMu.Unlock()
+		if idle {
+			return
+		}
+
+		parsed, err := e.invokeLambdaBounded(t)
+		entry := &cacheEntry{parsed: parsed, err: err, timestamp: time.Now()}
+		cacheMu.Lock()
+		if err != nil {
+			// keep serving the last good result; only refresh the error so
+			// _scrape_error can flip without discarding stale-but-valid data.
+			if prev, ok := cache[key]; ok && prev.parsed != nil {
+				entry.parsed = prev.parsed
+				entry.timestamp = prev.timestamp
 			}
 		}
-		labels := []string{}
-		for k := range lm {
-			labels = append(labels, k)
+		cache[key] = entry
+		cacheMu.Unlock()
+	}
+}
+
+// invokeLambdaBounded runs invokeLambda under invokeSem and the target's
+// timeout, and additionally fires a throwaway async invoke to pre-warm the
+// Lambda's container when running in "warm" invocation-type mode.
+func (e *Exporter) invokeLambdaBounded(t lambdaTarget) (map[string]*dto.MetricFamily, error) {
+	invokeSem <- struct{}{}
+	defer func() { <-invokeSem }()
+
+	timeout := e.timeout
+	if t.Timeout > 0 {
+		timeout = t.Timeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if lambdaInvocationType == lambdaInvocationTypeWarm {
+		go e.warmInvoke(t)
+	}
+	return e.invokeLambda(ctx, t)
+}
+
+// warmInvoke issues a fire-and-forget Event invocation so the Lambda's
+// execution environment stays initialized between real scrapes.
+func (e *Exporter) warmInvoke(t lambdaTarget) {
+	ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+	defer cancel()
+	input := &lambda.InvokeInput{
+		FunctionName:   aws.String(t.FunctionName),
+		InvocationType: aws.String(lambda.InvocationTypeEvent),
+	}
+	if t.Qualifier != "" {
+		input.Qualifier = aws.String(t.Qualifier)
+	}
+	if _, err := e.lambdaClientFor(t).InvokeWithContext(ctx, input); err != nil {
+		logrus.Debugf("warm invoke failed for %s: %s", t.FunctionName, err)
+	}
+}
+
+// invokeAll fans out to every target concurrently, reading from the TTL
+// cache so individual invocation failures never fail the whole scrape.
+func (e *Exporter) invokeAll() []invocation {
+	results := make([]invocation, len(e.targets))
+	var wg sync.WaitGroup
+	for i, t := range e.targets {
+		wg.Add(1)
+		go func(i int, t lambdaTarget) {
+			defer wg.Done()
+			parsed, err, lastSuccess := e.invokeCached(t)
+			if err != nil {
+				invokeErrorsTotal.WithLabelValues(t.FunctionName).Inc()
+			}
+			results[i] = invocation{target: t, parsed: parsed, err: err, lastSuccess: lastSuccess}
+		}(i, t)
+	}
+	wg.Wait()
+	return results
+}
+
+func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- lastSuccessDesc
+	ch <- scrapeErrorDesc
+
+	for _, inv := range e.invokeAll() {
+		if inv.err != nil {
+			logrus.Error(inv.err)
+			continue
+		}
+
+		for _, mf := range inv.parsed {
+			key := descKey{functionName: inv.target.FunctionName, qualifier: inv.target.Qualifier, metricName: *mf.Name}
+			descMapMu.Lock()
+			_, ok := descMap[key]
+			descMapMu.Unlock()
+			if ok {
+				continue
+			}
+
+			lm := make(map[string]bool)
+			for _, m := range mf.GetMetric() {
+				for _, l := range m.GetLabel() {
+					lm[l.GetName()] = true
+				}
+			}
+			dynamicLabels := []string{}
+			for k := range lm {
+				dynamicLabels = append(dynamicLabels, k)
+			}
+			sort.Strings(dynamicLabels)
+			labels := append([]string{"function_name", "qualifier"}, dynamicLabels...)
+			desc := prometheus.NewDesc(
+				*mf.Name,
+				*mf.Help,
+				labels,
+				nil,
+			)
+			descMapMu.Lock()
+			descMap[key] = &descEntry{desc: desc, labels: dynamicLabels}
+			descMapMu.Unlock()
+			ch <- desc
 		}
-		sort.Strings(labels)
-		desc := prometheus.NewDesc(
-			*mf.Name,
-			*mf.Help,
-			labels,
-			nil,
-		)
-		descMap[*mf.Name] = desc
-		ch <- desc
 	}
 }
 
 func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
-	parsed, err := e.invokeLambda()
-	if err != nil {
-		log.Error(err)
-		return
-	}
+	for _, inv := range e.invokeAll() {
+		errValue := 0.0
+		if inv.err != nil {
+			errValue = 1.0
+		}
+		ch <- prometheus.MustNewConstMetric(scrapeErrorDesc, prometheus.GaugeValue, errValue, inv.target.FunctionName, inv.target.Qualifier)
+		if !inv.lastSuccess.IsZero() {
+			ch <- prometheus.MustNewConstMetric(lastSuccessDesc, prometheus.GaugeValue, float64(inv.lastSuccess.Unix()), inv.target.FunctionName, inv.target.Qualifier)
+		}
 
-	for _, mf := range parsed {
-		for _, m := range mf.GetMetric() {
-			if desc, ok := descMap[*mf.Name]; ok {
-				value := 0.0
-				var valueType prometheus.ValueType
-				switch *mf.Type {
-				case dto.MetricType_COUNTER:
-					value = *m.Counter.Value
-					valueType = prometheus.CounterValue
-				case dto.MetricType_GAUGE:
-					value = *m.Gauge.Value
-					valueType = prometheus.GaugeValue
-				case dto.MetricType_SUMMARY:
-					// unsupported
-				case dto.MetricType_HISTOGRAM:
-					// unsupported
+		if inv.err != nil {
+			logrus.Error(inv.err)
+			continue
+		}
+
+		for _, mf := range inv.parsed {
+			key := descKey{functionName: inv.target.FunctionName, qualifier: inv.target.Qualifier, metricName: *mf.Name}
+			descMapMu.Lock()
+			entry, ok := descMap[key]
+			descMapMu.Unlock()
+			if !ok {
+				continue
+			}
+
+			for _, m := range mf.GetMetric() {
+				if !labelNamesMatch(entry.labels, m) {
+					logrus.Errorf("skipping %s for %s/%s: label set %v does not match %v registered in Describe", *mf.Name, inv.target.FunctionName, inv.target.Qualifier, dynamicLabelNames(m), entry.labels)
+					continue
 				}
+				desc := entry.desc
 				labels := m.GetLabel()
 				sort.Slice(labels, func(i, j int) bool {
 					return labels[i].GetName() < labels[j].GetName()
 				})
-				labelValues := []string{}
+				labelValues := []string{inv.target.FunctionName, inv.target.Qualifier}
 				for _, l := range labels {
 					labelValues = append(labelValues, l.GetValue())
 				}
-				m := prometheus.MustNewConstMetric(desc, valueType, value, labelValues...)
-				ch <- m
+
+				var metric prometheus.Metric
+				var err error
+				switch *mf.Type {
+				case dto.MetricType_COUNTER:
+					metric, err = prometheus.NewConstMetric(desc, prometheus.CounterValue, m.Counter.GetValue(), labelValues...)
+				case dto.MetricType_GAUGE:
+					metric, err = prometheus.NewConstMetric(desc, prometheus.GaugeValue, m.Gauge.GetValue(), labelValues...)
+				case dto.MetricType_SUMMARY:
+					quantiles := make(map[float64]float64)
+					for _, q := range m.Summary.GetQuantile() {
+						quantiles[q.GetQuantile()] = q.GetValue()
+					}
+					metric, err = prometheus.NewConstSummary(desc, m.Summary.GetSampleCount(), m.Summary.GetSampleSum(), quantiles, labelValues...)
+				case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+					if m.Histogram.Schema != nil {
+						metric, err = prometheus.NewConstNativeHistogram(
+							desc,
+							m.Histogram.GetSampleCount(),
+							m.Histogram.GetSampleSum(),
+							nativeHistogramBuckets(m.Histogram.GetPositiveSpan(), m.Histogram.GetPositiveDelta()),
+							nativeHistogramBuckets(m.Histogram.GetNegativeSpan(), m.Histogram.GetNegativeDelta()),
+							m.Histogram.GetZeroCount(),
+							m.Histogram.GetSchema(),
+							m.Histogram.GetZeroThreshold(),
+							time.Time{},
+							labelValues...,
+						)
+					} else {
+						buckets := make(map[float64]uint64)
+						for _, b := range m.Histogram.GetBucket() {
+							buckets[b.GetUpperBound()] = b.GetCumulativeCount()
+						}
+						metric, err = prometheus.NewConstHistogram(desc, m.Histogram.GetSampleCount(), m.Histogram.GetSampleSum(), buckets, labelValues...)
+					}
+				}
+				if err != nil {
+					logrus.Errorf("could not create metric %s for %s/%s: %s", *mf.Name, inv.target.FunctionName, inv.target.Qualifier, err)
+					continue
+				}
+				ch <- metric
 			}
 		}
 	}
 }
 
+// nativeHistogramBuckets expands a native histogram's delta-encoded spans
+// into the absolute bucket-index -> count map NewConstNativeHistogram expects.
+func nativeHistogramBuckets(spans []*dto.BucketSpan, deltas []int64) map[int]int64 {
+	buckets := make(map[int]int64)
+	bucketIdx := int32(0)
+	count := int64(0)
+	deltaIdx := 0
+	for _, span := range spans {
+		bucketIdx += span.GetOffset()
+		for i := uint32(0); i < span.GetLength(); i++ {
+			count += deltas[deltaIdx]
+			buckets[int(bucketIdx)] = count
+			deltaIdx++
+			bucketIdx++
+		}
+	}
+	return buckets
+}
+
+// lambdaResult is the JSON envelope a Lambda probe returns. Result carries
+// Prometheus text format for backward compatibility; a Lambda may instead
+// set ContentType and either Result (text-based formats) or ResultB64
+// (binary formats such as protobuf) to preserve native histograms,
+// exemplars, and other data the text format can't express.
 type lambdaResult struct {
-	Result string
+	Result      string `json:"result"`
+	ResultB64   string `json:"result_b64"`
+	ContentType string `json:"content_type"`
+}
+
+// decodeMetricFamilies parses a lambdaResult's payload using the format
+// implied by ContentType, defaulting to Prometheus text format when unset.
+func decodeMetricFamilies(jsonResult lambdaResult) (map[string]*dto.MetricFamily, error) {
+	var reader io.Reader
+	if jsonResult.ResultB64 != "" {
+		raw, err := base64.StdEncoding.DecodeString(jsonResult.ResultB64)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(raw)
+	} else {
+		reader = strings.NewReader(jsonResult.Result)
+	}
+
+	format := expfmt.FmtText
+	if jsonResult.ContentType != "" {
+		format = expfmt.ResponseFormat(http.Header{"Content-Type": []string{jsonResult.ContentType}})
+	}
+
+	decoder := expfmt.NewDecoder(reader, format)
+	parsed := make(map[string]*dto.MetricFamily)
+	for {
+		var mf dto.MetricFamily
+		if err := decoder.Decode(&mf); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		parsed[mf.GetName()] = &mf
+	}
+	return parsed, nil
 }
 
-func (e *Exporter) invokeLambda() (map[string]*dto.MetricFamily, error) {
+func (e *Exporter) invokeLambda(ctx context.Context, t lambdaTarget) (map[string]*dto.MetricFamily, error) {
 	input := &lambda.InvokeInput{
-		FunctionName: aws.String(e.functionName),
+		FunctionName: aws.String(t.FunctionName),
+	}
+	if lambdaInvocationType == lambda.InvocationTypeDryRun {
+		input.InvocationType = aws.String(lambda.InvocationTypeDryRun)
+	}
+	if t.Qualifier != "" {
+		input.Qualifier = aws.String(t.Qualifier)
+	}
+	if len(t.Payload) > 0 {
+		payload, err := json.Marshal(t.Payload)
+		if err != nil {
+			return nil, err
+		}
+		input.Payload = payload
 	}
 
-	result, err := e.lambdaClient.Invoke(input)
+	timer := prometheus.NewTimer(lambdaInvokeDuration.WithLabelValues(t.FunctionName))
+	result, err := e.lambdaClientFor(t).InvokeWithContext(ctx, input)
+	timer.ObserveDuration()
 	if err != nil {
+		lambdaInvokeErrorsTotal.WithLabelValues(t.FunctionName, e.region, invokeErrorType(err)).Inc()
 		return nil, err
 	}
+	if lambdaInvocationType == lambda.InvocationTypeDryRun {
+		// DryRun only validates permissions/parameters; there is no payload
+		// to parse, so report no metrics rather than a parse failure.
+		return map[string]*dto.MetricFamily{}, nil
+	}
 	if *result.StatusCode != 200 {
-		return nil, fmt.Errorf("lambda invoke error: %s\n", result.FunctionError)
+		lambdaInvokeErrorsTotal.WithLabelValues(t.FunctionName, e.region, "function_error").Inc()
+		return nil, fmt.Errorf("lambda invoke error: %s\n", aws.StringValue(result.FunctionError))
 	}
-	log.Debugf("lambda log result: %s\n", result.LogResult)
+	logrus.Debugf("lambda log result: %s\n", aws.StringValue(result.LogResult))
 
 	var jsonResult lambdaResult
 	err = json.Unmarshal(result.Payload, &jsonResult)
 	if err != nil {
+		lambdaInvokeErrorsTotal.WithLabelValues(t.FunctionName, e.region, "unmarshal_error").Inc()
 		return nil, err
 	}
 
-	var parser expfmt.TextParser
-	parsed, err := parser.TextToMetricFamilies(strings.NewReader(jsonResult.Result))
+	parsed, err := decodeMetricFamilies(jsonResult)
 	if err != nil {
+		lambdaInvokeErrorsTotal.WithLabelValues(t.FunctionName, e.region, "parse_error").Inc()
 		return nil, err
 	}
 
 	return parsed, nil
 }
 
+// invokeErrorType classifies an error from invoking Lambda into a low
+// cardinality label value, using the AWS error code when available.
+func invokeErrorType(err error) string {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code()
+	}
+	return "unknown_error"
+}
+
 func handler(w http.ResponseWriter, r *http.Request) {
-	functionName := r.URL.Query()["function_name[]"][0]
-	log.Debugln("function name:", functionName)
+	query := r.URL.Query()
+	functionNames := query["function_name[]"]
+	if len(functionNames) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("function_name[] parameter is missing"))
+		return
+	}
+	qualifiers := query["qualifier[]"]
+	modules := query["module[]"]
+
+	payload := map[string]interface{}{}
+	for name, values := range query {
+		switch name {
+		case "function_name[]", "qualifier[]", "module[]":
+			continue
+		}
+		if len(values) > 0 {
+			payload[name] = values[0]
+		}
+	}
+
+	targets := make([]lambdaTarget, len(functionNames))
+	for i, functionName := range functionNames {
+		t := lambdaTarget{FunctionName: functionName, Payload: payload}
+		if i < len(qualifiers) {
+			t.Qualifier = qualifiers[i]
+		}
+		if i < len(modules) {
+			if module, ok := appConfig.Modules[modules[i]]; ok {
+				if t.Qualifier == "" {
+					t.Qualifier = module.Qualifier
+				}
+				t.RoleARN = module.RoleARN
+				t.Timeout = module.Timeout
+				merged := map[string]interface{}{}
+				for k, v := range module.Payload {
+					merged[k] = v
+				}
+				for k, v := range payload {
+					merged[k] = v
+				}
+				t.Payload = merged
+			} else {
+				logrus.Warnln("unknown module", modules[i])
+			}
+		}
+		targets[i] = t
+	}
+	logrus.Debugln("function names:", functionNames)
 
 	region, err := GetDefaultRegion()
 	if err != nil {
-		log.Warnln("Couldn't get region", err)
+		logrus.Warnln("Couldn't get region", err)
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(fmt.Sprintf("Couldn't get region %s", err)))
 		return
 	}
-	exporter, err := NewExporter(region, functionName)
+	exporter, err := NewExporter(region, targets)
 	if err != nil {
-		log.Warnln("Couldn't create", err)
+		logrus.Warnln("Couldn't create", err)
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(fmt.Sprintf("Couldn't create %s", err)))
 		return
@@ -173,7 +727,7 @@ func handler(w http.ResponseWriter, r *http.Request) {
 	registry := prometheus.NewRegistry()
 	err = registry.Register(exporter)
 	if err != nil {
-		log.Errorln("Couldn't register collector:", err)
+		logrus.Errorln("Couldn't register collector:", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(fmt.Sprintf("Couldn't register collector: %s", err)))
 		return
@@ -187,8 +741,9 @@ func handler(w http.ResponseWriter, r *http.Request) {
 		registry,
 		promhttp.HandlerFor(gatherers,
 			promhttp.HandlerOpts{
-				ErrorLog:      log.NewErrorLogger(),
+				ErrorLog:      logrus.StandardLogger(),
 				ErrorHandling: promhttp.ContinueOnError,
+				Registry:      prometheus.DefaultRegisterer,
 			}),
 	)
 	h.ServeHTTP(w, r)
@@ -196,6 +751,10 @@ func handler(w http.ResponseWriter, r *http.Request) {
 
 var region string
 
+// appConfig holds the parsed --config.file, or an empty Config when none
+// was given. Read-only after main() loads it.
+var appConfig = &Config{}
+
 func GetDefaultRegion() (string, error) {
 	if region != "" {
 		return region, nil
@@ -227,14 +786,29 @@ func GetDefaultRegion() (string, error) {
 type config struct {
 	listenAddress string
 	metricsPath   string
+	configFile    string
 }
 
 func main() {
 	var cfg config
 	flag.StringVar(&cfg.listenAddress, "web.listen-address", ":9408", "Address to listen on for web endpoints.")
 	flag.StringVar(&cfg.metricsPath, "web.telemetry-path", "/metrics", "Path under which to expose metrics.")
+	flag.StringVar(&cfg.configFile, "config.file", "", "Path to a config file mapping probe modules to Lambda invocation parameters.")
+	flag.DurationVar(&lambdaTimeout, "lambda.timeout", lambdaTimeout, "Timeout for each individual Lambda invocation.")
+	flag.IntVar(&lambdaConcurrency, "lambda.max-concurrent-invocations", lambdaConcurrency, "Maximum number of Lambda functions invoked concurrently per scrape.")
+	flag.DurationVar(&lambdaRefreshInterval, "lambda.refresh-interval", lambdaRefreshInterval, "How often cached Lambda results are refreshed in the background.")
+	flag.DurationVar(&lambdaRefreshIdleTimeout, "lambda.refresh-idle-timeout", lambdaRefreshIdleTimeout, "Stop background-refreshing and evict a target's cache entry once it has gone this long without being scraped.")
+	flag.StringVar(&lambdaInvocationType, "lambda.invocation-type", lambdaInvocationType, "Lambda InvocationType to use: RequestResponse, DryRun, or Warm (RequestResponse plus background container warming).")
 	flag.Parse()
 
+	loadedConfig, err := LoadConfig(cfg.configFile)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	appConfig = loadedConfig
+
+	invokeSem = make(chan struct{}, lambdaConcurrency)
+
 	http.HandleFunc(cfg.metricsPath, handler)
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`<html>
@@ -246,9 +820,9 @@ func main() {
 			</html>`))
 	})
 
-	log.Infoln("Listening on", cfg.listenAddress)
-	err := http.ListenAndServe(cfg.listenAddress, nil)
+	logrus.Infoln("Listening on", cfg.listenAddress)
+	err = http.ListenAndServe(cfg.listenAddress, nil)
 	if err != nil {
-		log.Fatal(err)
+		logrus.Fatal(err)
 	}
 }
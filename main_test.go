@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// fakeLambdaClient stubs just InvokeWithContext, which is all Exporter
+// calls; every other lambdaiface.LambdaAPI method is left nil and must not
+// be exercised by the code under test.
+type fakeLambdaClient struct {
+	lambdaiface.LambdaAPI
+	invoke func(*lambda.InvokeInput) (*lambda.InvokeOutput, error)
+}
+
+func (f *fakeLambdaClient) InvokeWithContext(_ aws.Context, input *lambda.InvokeInput, _ ...request.Option) (*lambda.InvokeOutput, error) {
+	return f.invoke(input)
+}
+
+// newTestExporter builds an Exporter that serves text, skipping real AWS
+// calls entirely by pre-seeding its client cache with a fake that always
+// returns text.
+func newTestExporter(t lambdaTarget, text string) *Exporter {
+	payload, _ := json.Marshal(lambdaResult{Result: text})
+	client := &fakeLambdaClient{
+		invoke: func(*lambda.InvokeInput) (*lambda.InvokeOutput, error) {
+			return &lambda.InvokeOutput{StatusCode: aws.Int64(200), Payload: payload}, nil
+		},
+	}
+	return &Exporter{
+		targets:     []lambdaTarget{t},
+		timeout:     time.Second,
+		concurrency: 1,
+		clients:     map[string]lambdaiface.LambdaAPI{t.RoleARN: client},
+	}
+}
+
+func TestNativeHistogramBuckets(t *testing.T) {
+	cases := []struct {
+		name   string
+		spans  []*dto.BucketSpan
+		deltas []int64
+		want   map[int]int64
+	}{
+		{
+			name:   "no spans",
+			spans:  nil,
+			deltas: nil,
+			want:   map[int]int64{},
+		},
+		{
+			name: "single contiguous span",
+			spans: []*dto.BucketSpan{
+				{Offset: proto32(0), Length: proto32u(3)},
+			},
+			deltas: []int64{1, 1, -1},
+			want:   map[int]int64{0: 1, 1: 2, 2: 1},
+		},
+		{
+			name: "offset skips indices",
+			spans: []*dto.BucketSpan{
+				{Offset: proto32(2), Length: proto32u(1)},
+				{Offset: proto32(3), Length: proto32u(2)},
+			},
+			deltas: []int64{5, -2, 1},
+			want:   map[int]int64{2: 5, 6: 3, 7: 4},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := nativeHistogramBuckets(c.spans, c.deltas)
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("nativeHistogramBuckets(%v, %v) = %v, want %v", c.spans, c.deltas, got, c.want)
+			}
+		})
+	}
+}
+
+func TestDecodeMetricFamilies(t *testing.T) {
+	cases := []struct {
+		name    string
+		result  lambdaResult
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "defaults to text format",
+			result: lambdaResult{
+				Result: "foo_total 1\n",
+			},
+			want: []string{"foo_total"},
+		},
+		{
+			name: "explicit text content type",
+			result: lambdaResult{
+				Result:      "bar_total 2\n",
+				ContentType: "text/plain; version=0.0.4",
+			},
+			want: []string{"bar_total"},
+		},
+		{
+			name: "invalid base64",
+			result: lambdaResult{
+				ResultB64:   "not-valid-base64!",
+				ContentType: "application/vnd.google.protobuf",
+			},
+			wantErr: true,
+		},
+		{
+			name: "malformed text",
+			result: lambdaResult{
+				Result: "this is not a metric\n",
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			parsed, err := decodeMetricFamilies(c.result)
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("decodeMetricFamilies(%+v) = nil error, want error", c.result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decodeMetricFamilies(%+v) returned error: %s", c.result, err)
+			}
+			var names []string
+			for name := range parsed {
+				names = append(names, name)
+			}
+			if !reflect.DeepEqual(names, c.want) {
+				t.Errorf("decodeMetricFamilies(%+v) families = %v, want %v", c.result, names, c.want)
+			}
+		})
+	}
+}
+
+func TestLambdaTargetCacheKey(t *testing.T) {
+	base := lambdaTarget{FunctionName: "fn", Qualifier: "live"}
+	withPayload := lambdaTarget{FunctionName: "fn", Qualifier: "live", Payload: map[string]interface{}{"a": 1}}
+	withSamePayload := lambdaTarget{FunctionName: "fn", Qualifier: "live", Payload: map[string]interface{}{"a": 1}}
+	withOtherPayload := lambdaTarget{FunctionName: "fn", Qualifier: "live", Payload: map[string]interface{}{"a": 2}}
+
+	if base.cacheKey().payloadHash != "" {
+		t.Errorf("cacheKey() for a target with no payload should have an empty payloadHash, got %q", base.cacheKey().payloadHash)
+	}
+	if withPayload.cacheKey() == base.cacheKey() {
+		t.Errorf("cacheKey() should differ between a target with a payload and one without")
+	}
+	if withPayload.cacheKey() != withSamePayload.cacheKey() {
+		t.Errorf("cacheKey() should be equal for two targets with identical payloads")
+	}
+	if withPayload.cacheKey() == withOtherPayload.cacheKey() {
+		t.Errorf("cacheKey() should differ between targets with different payloads")
+	}
+
+	withRole := lambdaTarget{FunctionName: "fn", Qualifier: "live", RoleARN: "arn:aws:iam::111111111111:role/a"}
+	withOtherRole := lambdaTarget{FunctionName: "fn", Qualifier: "live", RoleARN: "arn:aws:iam::222222222222:role/b"}
+	if base.cacheKey() == withRole.cacheKey() {
+		t.Errorf("cacheKey() should differ between targets with and without a RoleARN")
+	}
+	if withRole.cacheKey() == withOtherRole.cacheKey() {
+		t.Errorf("cacheKey() should differ between targets assuming different roles")
+	}
+}
+
+func proto32(v int32) *int32 {
+	return &v
+}
+
+func proto32u(v uint32) *uint32 {
+	return &v
+}
+
+// TestCollectSummaryAndHistogram exercises the MustNewConstSummary and
+// MustNewConstHistogram dispatch Collect added for Summary and Histogram
+// metric families, and Describe's handling of the _sum/_count/_bucket
+// suffixes that make up those families.
+func TestCollectSummaryAndHistogram(t *testing.T) {
+	text := `# HELP latency_seconds Request latency in seconds.
+# TYPE latency_seconds summary
+latency_seconds{region="us-east-1",quantile="0.5"} 0.1
+latency_seconds{region="us-east-1",quantile="0.9"} 0.2
+latency_seconds_sum{region="us-east-1"} 1.5
+latency_seconds_count{region="us-east-1"} 10
+# HELP request_duration_seconds Request duration in seconds.
+# TYPE request_duration_seconds histogram
+request_duration_seconds_bucket{region="us-east-1",le="0.1"} 5
+request_duration_seconds_bucket{region="us-east-1",le="0.5"} 8
+request_duration_seconds_bucket{region="us-east-1",le="+Inf"} 10
+request_duration_seconds_sum{region="us-east-1"} 3.2
+request_duration_seconds_count{region="us-east-1"} 10
+`
+	target := lambdaTarget{FunctionName: "probe-fn", Qualifier: "live"}
+	exporter := newTestExporter(target, text)
+
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(exporter); err != nil {
+		t.Fatalf("Register: %s", err)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %s", err)
+	}
+
+	byName := make(map[string]*dto.MetricFamily)
+	for _, mf := range families {
+		byName[mf.GetName()] = mf
+	}
+
+	summary := byName["latency_seconds"]
+	if summary == nil {
+		t.Fatal("latency_seconds family missing from Gather output")
+	}
+	if got := summary.GetMetric()[0].Summary.GetSampleCount(); got != 10 {
+		t.Errorf("latency_seconds sample count = %d, want 10", got)
+	}
+	quantiles := make(map[float64]float64)
+	for _, q := range summary.GetMetric()[0].Summary.GetQuantile() {
+		quantiles[q.GetQuantile()] = q.GetValue()
+	}
+	if want := (map[float64]float64{0.5: 0.1, 0.9: 0.2}); !reflect.DeepEqual(quantiles, want) {
+		t.Errorf("latency_seconds quantiles = %v, want %v", quantiles, want)
+	}
+
+	histogram := byName["request_duration_seconds"]
+	if histogram == nil {
+		t.Fatal("request_duration_seconds family missing from Gather output")
+	}
+	if got := histogram.GetMetric()[0].Histogram.GetSampleCount(); got != 10 {
+		t.Errorf("request_duration_seconds sample count = %d, want 10", got)
+	}
+	buckets := make(map[float64]uint64)
+	for _, b := range histogram.GetMetric()[0].Histogram.GetBucket() {
+		buckets[b.GetUpperBound()] = b.GetCumulativeCount()
+	}
+	want := map[float64]uint64{0.1: 5, 0.5: 8, math.Inf(1): 10}
+	if !reflect.DeepEqual(buckets, want) {
+		t.Errorf("request_duration_seconds buckets = %v, want %v", buckets, want)
+	}
+}